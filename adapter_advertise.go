@@ -0,0 +1,41 @@
+//go:build linux || windows
+
+package main
+
+import "tinygo.org/x/bluetooth"
+
+// advertiser broadcasts a BroodMinder manufacturer payload from an adapter
+// (bm-scan emulate). BlueZ and WinRT can't change a running advertisement,
+// so each new payload stops, reconfigures and restarts it.
+type advertiser struct {
+	adv     *bluetooth.Advertisement
+	started bool
+}
+
+func newAdvertiser(adapter *bluetooth.Adapter) (*advertiser, error) {
+	return &advertiser{adv: adapter.DefaultAdvertisement()}, nil
+}
+
+// set advertises data from now on.
+func (a *advertiser) set(data []byte) error {
+	if err := a.stop(); err != nil {
+		return err
+	}
+	err := a.adv.Configure(bluetooth.AdvertisementOptions{
+		AdvertisementType: bluetooth.AdvertisingTypeNonConnInd,
+		ManufacturerData:  []bluetooth.ManufacturerDataElement{{CompanyID: broodMinderManufacturerID, Data: data}},
+	})
+	if err == nil {
+		err = a.adv.Start()
+	}
+	a.started = err == nil
+	return err
+}
+
+func (a *advertiser) stop() error {
+	if !a.started {
+		return nil
+	}
+	a.started = false
+	return a.adv.Stop()
+}
@@ -0,0 +1,426 @@
+//go:build linux
+
+package main
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// openAdapter returns the BlueZ adapter with the given ID (e.g. "hci1").
+// An empty ID selects the system default adapter.
+func openAdapter(id string) (*bluetooth.Adapter, error) {
+	if err := useBluezSocket(); err != nil {
+		return nil, err
+	}
+	if id == "" {
+		return bluetooth.DefaultAdapter, nil
+	}
+	return bluetooth.NewAdapter(id), nil
+}
+
+// powerCycleAdapter takes the adapter down and back up with hciconfig, which
+// recovers controllers that BlueZ has left wedged after a reset.
+func powerCycleAdapter(id string) error {
+	if id == "" {
+		id = "hci0"
+	}
+	if out, err := exec.Command("hciconfig", id, "down").CombinedOutput(); err != nil {
+		return fmt.Errorf("hciconfig %s down: %v: %s", id, err, strings.TrimSpace(string(out)))
+	}
+	time.Sleep(500 * time.Millisecond)
+	if out, err := exec.Command("hciconfig", id, "up").CombinedOutput(); err != nil {
+		return fmt.Errorf("hciconfig %s up: %v: %s", id, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// bluezMinVersion is the oldest BlueZ the BLE library supports.
+const bluezMinVersion = "5.48"
+
+// platformChecks are the BlueZ checks of "bm-scan doctor" for adapter id.
+func platformChecks(id string) []doctorCheck {
+	if id == "" {
+		id = "hci0"
+	}
+	return []doctorCheck{containerCheck(false), bluezCheck(), privilegeCheck(true), rfkillCheck(id), powerCheck(id)}
+}
+
+// systemBusSockets are where the D-Bus system bus socket is looked for when
+// neither -bluez-socket nor DBUS_SYSTEM_BUS_ADDRESS names one. The D-Bus
+// library only tries the first, and container images often lack the
+// /var/run link to /run.
+var systemBusSockets = []string{"/var/run/dbus/system_bus_socket", "/run/dbus/system_bus_socket"}
+
+// systemBusSocket returns the path of the system bus socket BlueZ will be
+// reached through: -bluez-socket, the path in DBUS_SYSTEM_BUS_ADDRESS, or
+// the first of systemBusSockets that exists.
+func systemBusSocket() string {
+	if bluezSocket != "" {
+		return bluezSocket
+	}
+	if addr := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS"); addr != "" {
+		path, _ := strings.CutPrefix(addr, "unix:path=")
+		return path
+	}
+	for _, p := range systemBusSockets {
+		if isSocket(p) {
+			return p
+		}
+	}
+	return systemBusSockets[0]
+}
+
+// useBluezSocket points the D-Bus library at systemBusSocket. A missing
+// socket is left for D-Bus to report, unless -bluez-socket named it.
+func useBluezSocket() error {
+	path := systemBusSocket()
+	switch {
+	case isSocket(path):
+		return os.Setenv("DBUS_SYSTEM_BUS_ADDRESS", "unix:path="+path)
+	case bluezSocket != "":
+		return fmt.Errorf("-bluez-socket: %s is not a socket", bluezSocket)
+	}
+	return nil
+}
+
+func isSocket(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// inContainer reports whether bm-scan runs in a Docker, Podman or
+// Kubernetes container.
+func inContainer() bool {
+	for _, f := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != ""
+}
+
+// containerBusFix is how to give a container the host's D-Bus socket.
+const containerBusFix = "BlueZ runs on the host; mount its D-Bus socket into the container (docker run -v /run/dbus:/run/dbus:ro ...), or mount it elsewhere and pass -bluez-socket PATH"
+
+// containerCheck reports whether a containerised bm-scan can reach the
+// host's BlueZ: it needs the host's D-Bus socket, and for -watchdog
+// (watchdog) the host network namespace, the only one the kernel offers
+// HCI sockets in, and CAP_NET_ADMIN, which Docker drops even for root, so
+// that hciconfig can power-cycle the adapter. Outside a container it is
+// skipped.
+func containerCheck(watchdog bool) doctorCheck {
+	c := doctorCheck{name: "container"}
+	if !inContainer() {
+		c.status, c.detail = doctorSkip, "not in a container"
+		return c
+	}
+	path := systemBusSocket()
+	if !isSocket(path) {
+		c.status, c.detail, c.fix = doctorFail, "in a container without the host's D-Bus socket ("+path+")", containerBusFix
+		return c
+	}
+	var problems, fixes []string
+	if !hciSockets() {
+		problems = append(problems, "no HCI sockets, so not in the host network namespace")
+		fixes = append(fixes, "run the container with --net=host")
+	}
+	if processCaps("CapEff")&(1<<capNetAdmin) == 0 {
+		problems = append(problems, "no CAP_NET_ADMIN")
+		fixes = append(fixes, "add --cap-add NET_ADMIN (or --privileged)")
+	}
+	if len(problems) == 0 {
+		c.status, c.detail = doctorOK, "in a container with the host's D-Bus socket and network"
+		return c
+	}
+	c.status = doctorWarn
+	if watchdog {
+		c.status = doctorFail
+	}
+	c.detail = "in a container with the host's D-Bus socket; -watchdog can't power-cycle the adapter: " + strings.Join(problems, "; ")
+	c.fix = strings.Join(fixes, "; ")
+	return c
+}
+
+// hciSockets reports whether the kernel lets this process open a raw HCI
+// socket, which it refuses outside the host network namespace.
+func hciSockets() bool {
+	const btprotoHCI = 1
+	fd, err := syscall.Socket(syscall.AF_BLUETOOTH, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, btprotoHCI)
+	if err != nil {
+		return false
+	}
+	syscall.Close(fd)
+	return true
+}
+
+// bluezCheck finds bluetoothd, which distributions install outside PATH,
+// and compares its version with bluezMinVersion.
+func bluezCheck() doctorCheck {
+	c := doctorCheck{name: "BlueZ"}
+	for _, bin := range []string{"bluetoothd", "/usr/libexec/bluetooth/bluetoothd", "/usr/lib/bluetooth/bluetoothd"} {
+		out, err := exec.Command(bin, "--version").Output()
+		if err != nil {
+			continue
+		}
+		v := strings.TrimSpace(string(out))
+		if !versionAtLeast(v, bluezMinVersion) {
+			c.status, c.detail = doctorFail, v
+			c.fix = "BlueZ " + bluezMinVersion + " or newer is needed; upgrade the OS or its bluez package"
+			return c
+		}
+		c.status, c.detail = doctorOK, v
+		return c
+	}
+	c.status, c.detail = doctorFail, "bluetoothd not found"
+	c.fix = "install BlueZ (sudo apt install bluez) and start it: sudo systemctl enable --now bluetooth"
+	return c
+}
+
+// versionAtLeast compares dotted version numbers; a version that doesn't
+// parse is never new enough.
+func versionAtLeast(v, min string) bool {
+	parse := func(s string) []int {
+		var n []int
+		for _, p := range strings.Split(s, ".") {
+			i, err := strconv.Atoi(p)
+			if err != nil {
+				return nil
+			}
+			n = append(n, i)
+		}
+		return n
+	}
+	a, b := parse(v), parse(min)
+	return a != nil && slices.Compare(a, b) >= 0
+}
+
+// privilegeCheck reports whether bm-scan can scan without root, judging
+// the groups and capabilities of this process (see privilegeFacts).
+func privilegeCheck(watchdog bool) doctorCheck {
+	p := privilegeFacts{euid: os.Geteuid(), bluetoothGID: -1}
+	p.groups, _ = os.Getgroups()
+	p.groups = append(p.groups, os.Getegid())
+	if g, err := user.LookupGroup("bluetooth"); err == nil {
+		if gid, err := strconv.Atoi(g.Gid); err == nil {
+			p.bluetoothGID = gid
+		}
+	}
+	if b, err := os.ReadFile("/proc/self/status"); err == nil {
+		p.status = string(b)
+	}
+	return p.check(watchdog)
+}
+
+// processCaps returns a capability set of this process from
+// /proc/self/status (e.g. "CapEff", "CapAmb"), or 0 if it can't be read.
+func processCaps(set string) uint64 {
+	b, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	return statusCaps(string(b), set)
+}
+
+// rfkillCheck reports whether adapter id exists and isn't blocked by a
+// radio kill switch.
+func rfkillCheck(id string) doctorCheck {
+	c := doctorCheck{name: "rfkill"}
+	dir := filepath.Join("/sys/class/bluetooth", id)
+	if _, err := os.Stat(dir); err != nil {
+		c.status, c.detail = doctorFail, "no adapter "+id
+		c.fix = "list adapters with bluetoothctl list and pass the right one with -adapter; on a Raspberry Pi, make sure config.txt doesn't disable Bluetooth (dtoverlay=disable-bt)"
+		return c
+	}
+	switches, _ := filepath.Glob(filepath.Join(dir, "rfkill*"))
+	for _, sw := range switches {
+		read := func(name string) bool {
+			b, _ := os.ReadFile(filepath.Join(sw, name))
+			return strings.TrimSpace(string(b)) == "1"
+		}
+		switch {
+		case read("hard"):
+			c.status, c.detail = doctorFail, id+" is hard-blocked"
+			c.fix = "a hardware switch or the firmware has disabled the radio; check for a wireless switch or BIOS setting"
+			return c
+		case read("soft"):
+			c.status, c.detail = doctorFail, id+" is soft-blocked"
+			c.fix = "sudo rfkill unblock bluetooth"
+			return c
+		}
+	}
+	c.status, c.detail = doctorOK, id+" is not blocked"
+	return c
+}
+
+// powerCheck reports whether adapter id is up, using hciconfig like
+// powerCycleAdapter.
+func powerCheck(id string) doctorCheck {
+	c := doctorCheck{name: "power"}
+	out, err := exec.Command("hciconfig", id).CombinedOutput()
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		c.status, c.detail = doctorSkip, "hciconfig not installed; the scan check covers it"
+	case err != nil:
+		c.status, c.detail = doctorFail, strings.TrimSpace(string(out))
+		c.fix = "list adapters with bluetoothctl list and pass the right one with -adapter"
+	case strings.Contains(string(out), "UP RUNNING"):
+		c.status, c.detail = doctorOK, id+" is up"
+	default:
+		c.status, c.detail = doctorFail, id+" is down"
+		c.fix = "bluetoothctl power on (or sudo hciconfig " + id + " up)"
+	}
+	return c
+}
+
+// adapterRemedy suggests a fix for an error enabling or scanning on a
+// BlueZ adapter.
+func adapterRemedy(err error) string {
+	s := err.Error()
+	container := inContainer()
+	switch {
+	case strings.Contains(s, "-bluez-socket"):
+		return containerBusFix
+	case strings.Contains(s, "system_bus_socket") && container:
+		return containerBusFix
+	case strings.Contains(s, "system_bus_socket"):
+		return "the D-Bus system bus isn't running"
+	case strings.Contains(s, "not powered"):
+		return "bluetoothctl power on"
+	case strings.Contains(s, "does not exist"):
+		return "list adapters with bluetoothctl list and pass the right one with -adapter"
+	case strings.Contains(s, "org.bluez was not provided"), strings.Contains(s, "ServiceUnknown"):
+		return "BlueZ is not running: sudo systemctl enable --now bluetooth"
+	case (strings.Contains(s, "AccessDenied") || strings.Contains(s, "Rejected send message") || strings.Contains(s, "not allowed")) && container:
+		return "the host's D-Bus refused the container: run it as root (--user 0) or with the host's bluetooth group (--group-add $(getent group bluetooth | cut -d: -f3)); on AppArmor hosts also add --security-opt apparmor=unconfined"
+	case strings.Contains(s, "AccessDenied"), strings.Contains(s, "Rejected send message"), strings.Contains(s, "not allowed"):
+		return "D-Bus refused access to BlueZ: run with sudo, or add the user to the bluetooth group and log in again (see bm-scan -check-perms)"
+	}
+	return "run with sudo and check that BlueZ is running (systemctl status bluetooth)"
+}
+
+// Bluetooth management interface (mgmt-api.txt): commands and events on an
+// HCI socket bound to the control channel, each a little-endian header of
+// opcode (or event code), controller index and parameter length.
+const (
+	hciChannelControl      = 3
+	hciDevNone             = 0xffff
+	mgmtReadDefSysConfig   = 0x004b
+	mgmtSetDefSysConfig    = 0x004c
+	mgmtEvCmdComplete      = 0x0001
+	mgmtEvCmdStatus        = 0x0002
+	mgmtLEScanIntDiscovery = 0x0011 // system configuration types, in 0.625 ms units
+	mgmtLEScanWinDiscovery = 0x0012
+)
+
+// mgmtStatusText explains the mgmt status codes setScanParams can get.
+var mgmtStatusText = map[byte]string{
+	0x01: "not supported by this kernel (Linux 5.10 or newer is needed)",
+	0x0d: "invalid parameters",
+	0x11: "no such adapter",
+	0x14: "permission denied: run as root or grant CAP_NET_ADMIN",
+}
+
+// setScanParams sets the LE scan interval and window the kernel uses when
+// BlueZ discovers devices on adapter id, the ScanIntervalDiscovery and
+// ScanWindowDiscovery of BlueZ's main.conf. They take effect the next time
+// discovery starts and apply to every program scanning on the adapter.
+// restore puts back the previous values.
+func setScanParams(id string, p scanParams) (restore func() error, err error) {
+	index, err := strconv.Atoi(strings.TrimPrefix(cmp.Or(id, "hci0"), "hci"))
+	if err != nil {
+		return nil, fmt.Errorf("adapter %q: not an hciN name", id)
+	}
+	old, err := mgmtCommand(uint16(index), mgmtReadDefSysConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	var prev []byte
+	for b := old; len(b) >= 3 && len(b) >= 3+int(b[2]); b = b[3+int(b[2]):] {
+		if t := binary.LittleEndian.Uint16(b); t == mgmtLEScanIntDiscovery || t == mgmtLEScanWinDiscovery {
+			prev = append(prev, b[:3+int(b[2])]...)
+		}
+	}
+	interval, window := p.units()
+	tlv := func(typ, v uint16) []byte {
+		return binary.LittleEndian.AppendUint16(append(binary.LittleEndian.AppendUint16(nil, typ), 2), v)
+	}
+	if _, err := mgmtCommand(uint16(index), mgmtSetDefSysConfig, append(tlv(mgmtLEScanIntDiscovery, interval), tlv(mgmtLEScanWinDiscovery, window)...)); err != nil {
+		return nil, err
+	}
+	return func() error {
+		if len(prev) == 0 {
+			return nil
+		}
+		_, err := mgmtCommand(uint16(index), mgmtSetDefSysConfig, prev)
+		return err
+	}, nil
+}
+
+// mgmtCommand sends one management command to controller index and returns
+// the parameters of its reply. The control channel also carries events for
+// other programs' commands and discoveries, which are skipped.
+func mgmtCommand(index, opcode uint16, params []byte) ([]byte, error) {
+	const btprotoHCI = 1
+	fd, err := syscall.Socket(syscall.AF_BLUETOOTH, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, btprotoHCI)
+	if err != nil {
+		return nil, fmt.Errorf("management socket: %w", err)
+	}
+	defer syscall.Close(fd)
+	// struct sockaddr_hci, which package syscall has no Sockaddr for
+	addr := [3]uint16{syscall.AF_BLUETOOTH, hciDevNone, hciChannelControl}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_BIND, uintptr(fd), uintptr(unsafe.Pointer(&addr)), unsafe.Sizeof(addr)); errno != 0 {
+		return nil, fmt.Errorf("management socket: %w", errno)
+	}
+	// SO_RCVTIMEO only fires when the socket goes quiet; a busy control
+	// channel could feed events forever, so the loop also has a deadline.
+	const replyTimeout = 2 * time.Second
+	deadline := time.Now().Add(replyTimeout)
+	tv := syscall.NsecToTimeval(int64(replyTimeout))
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+		return nil, fmt.Errorf("management socket: %w", err)
+	}
+
+	cmd := binary.LittleEndian.AppendUint16(nil, opcode)
+	cmd = binary.LittleEndian.AppendUint16(cmd, index)
+	cmd = binary.LittleEndian.AppendUint16(cmd, uint16(len(params)))
+	if _, err := syscall.Write(fd, append(cmd, params...)); err != nil {
+		return nil, fmt.Errorf("management command 0x%04x: %w", opcode, err)
+	}
+	buf := make([]byte, 1024)
+	for {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("management command 0x%04x: no reply within %v", opcode, replyTimeout)
+		}
+		n, err := syscall.Read(fd, buf)
+		if err != nil {
+			return nil, fmt.Errorf("management command 0x%04x: %w", opcode, err)
+		}
+		// event header (6 bytes), then the command's opcode and status
+		ev := buf[:n]
+		if len(ev) < 9 || binary.LittleEndian.Uint16(ev[2:]) != index || binary.LittleEndian.Uint16(ev[6:]) != opcode {
+			continue
+		}
+		switch code := binary.LittleEndian.Uint16(ev); {
+		case code != mgmtEvCmdComplete && code != mgmtEvCmdStatus:
+			continue
+		case ev[8] != 0:
+			return nil, fmt.Errorf("hci%d: %s", index, cmp.Or(mgmtStatusText[ev[8]], fmt.Sprintf("management status 0x%02x", ev[8])))
+		}
+		return ev[9:], nil
+	}
+}
@@ -0,0 +1,66 @@
+//go:build !linux && !windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// openAdapter returns the system default adapter. Only Linux (BlueZ) can
+// address a specific adapter by ID.
+func openAdapter(id string) (*bluetooth.Adapter, error) {
+	if err := defaultAdapterOnly(runtime.GOOS, id, bluezSocket); err != nil {
+		return nil, err
+	}
+	return bluetooth.DefaultAdapter, nil
+}
+
+// powerCycleAdapter is not supported off Linux; the watchdog just restarts
+// the scan.
+func powerCycleAdapter(id string) error {
+	return fmt.Errorf("power-cycling adapters is only supported on Linux")
+}
+
+// setScanParams is not supported off Linux: CoreBluetooth chooses its own
+// scan timing.
+func setScanParams(id string, p scanParams) (restore func() error, err error) {
+	return nil, fmt.Errorf("-le-scan-interval and -le-scan-window are only supported on Linux")
+}
+
+// platformChecks has nothing to check off Linux; the doctor's scan check
+// still runs.
+func platformChecks(id string) []doctorCheck {
+	return []doctorCheck{{name: "platform", status: doctorSkip, detail: "no system checks on " + runtime.GOOS}}
+}
+
+// containerCheck is skipped: containers only reach Bluetooth through a
+// Linux host's BlueZ.
+func containerCheck(watchdog bool) doctorCheck {
+	return doctorCheck{name: "container", status: doctorSkip, detail: "no container checks on " + runtime.GOOS}
+}
+
+// privilegeCheck has nothing to check off Linux, where the OS asks the
+// user for Bluetooth access instead.
+func privilegeCheck(watchdog bool) doctorCheck {
+	return doctorCheck{name: "privileges", status: doctorSkip, detail: "no permission checks on " + runtime.GOOS}
+}
+
+// adapterRemedy suggests a fix for an error enabling or scanning on the
+// adapter.
+func adapterRemedy(err error) string {
+	return "turn Bluetooth on and grant the terminal app Bluetooth access (System Settings > Privacy & Security > Bluetooth)"
+}
+
+// advertiser is not available here: tinygo bluetooth can only advertise
+// on Linux and Windows.
+type advertiser struct{}
+
+func newAdvertiser(adapter *bluetooth.Adapter) (*advertiser, error) {
+	return nil, fmt.Errorf("advertising is not supported on %s (Linux and Windows only)", runtime.GOOS)
+}
+
+func (a *advertiser) set(data []byte) error { return nil }
+func (a *advertiser) stop() error           { return nil }
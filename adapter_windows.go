@@ -0,0 +1,55 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// openAdapter returns the system default adapter. WinRT scans on whichever
+// Bluetooth radio Windows has enabled; it can't be chosen by ID.
+func openAdapter(id string) (*bluetooth.Adapter, error) {
+	if err := defaultAdapterOnly(runtime.GOOS, id, bluezSocket); err != nil {
+		return nil, err
+	}
+	return bluetooth.DefaultAdapter, nil
+}
+
+// powerCycleAdapter is not supported on Windows; the watchdog just restarts
+// the scan.
+func powerCycleAdapter(id string) error {
+	return fmt.Errorf("power-cycling adapters is not supported on Windows")
+}
+
+// setScanParams is not supported on Windows: the BLE library's watcher
+// doesn't expose WinRT's scan timing.
+func setScanParams(id string, p scanParams) (restore func() error, err error) {
+	return nil, fmt.Errorf("-le-scan-interval and -le-scan-window are only supported on Linux")
+}
+
+// platformChecks has nothing to check on Windows; the doctor's scan check
+// still runs.
+func platformChecks(id string) []doctorCheck {
+	return []doctorCheck{{name: "platform", status: doctorSkip, detail: "no system checks on Windows"}}
+}
+
+// containerCheck is skipped: containers only reach Bluetooth through a
+// Linux host's BlueZ.
+func containerCheck(watchdog bool) doctorCheck {
+	return doctorCheck{name: "container", status: doctorSkip, detail: "no container checks on Windows"}
+}
+
+// privilegeCheck passes: scanning through WinRT needs no administrator
+// rights.
+func privilegeCheck(watchdog bool) doctorCheck {
+	return doctorCheck{name: "privileges", status: doctorOK, detail: "no administrator rights needed on Windows"}
+}
+
+// adapterRemedy suggests a fix for an error enabling or scanning on the
+// adapter.
+func adapterRemedy(err error) string {
+	return "turn Bluetooth on (Settings > Bluetooth & devices); scanning needs Windows 10 or newer and a Bluetooth LE (4.0+) radio"
+}